@@ -0,0 +1,213 @@
+// Copyright (c) 2013 Ostap Cherkashin. You can use this source code
+// under the terms of the MIT License found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// catalogSection holds the raw key/value pairs of one
+// `[source "name"]` block, in the order AddCatalog needs to turn them into
+// a source.
+type catalogSection struct {
+	name    string
+	lineNo  int
+	kv      map[string]string
+	columns []string
+}
+
+// AddCatalog reads a small INI-style catalog file and loads every
+// `[source "name"]` section it declares. Each section's path is resolved
+// relative to the catalog's own directory, and its delimiter/skip/
+// compression/column overrides take precedence over whatever Add would
+// otherwise infer from the file itself.
+//
+//	[source "customers"]
+//	path = customers.csv
+//	format = csv
+//	delimiter = ,
+//	skip = 1
+//	compression = gzip
+//	column = id:int
+//	column = name:string
+//	column = created:datetime
+//
+// A file with no header row at all (every row is data) is declared with
+// "header = false"; since there is then no header row to read names from,
+// such a section must also declare its columns explicitly.
+func (s Store) AddCatalog(catalogPath string) error {
+	sections, err := parseCatalog(catalogPath)
+	if err != nil {
+		return fmt.Errorf("failed to read catalog %v: %v", catalogPath, err)
+	}
+
+	dir := filepath.Dir(catalogPath)
+	for _, sec := range sections {
+		src, err := sec.toSource(dir)
+		if err != nil {
+			return fmt.Errorf("%v:%d: %v", catalogPath, sec.lineNo, err)
+		}
+
+		if err := s.addSource(src); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseCatalog hand-rolls a minimal INI reader: `[source "name"]` section
+// headers, `key = value` pairs, '#' and ';' comments, blank lines ignored.
+// A repeated `column` key accumulates instead of overwriting.
+func parseCatalog(catalogPath string) ([]*catalogSection, error) {
+	file, err := os.Open(catalogPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var sections []*catalogSection
+	var cur *catalogSection
+
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		ln := strings.TrimSpace(scanner.Text())
+		if ln == "" || strings.HasPrefix(ln, "#") || strings.HasPrefix(ln, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(ln, "[") {
+			name, err := parseSectionHeader(ln)
+			if err != nil {
+				return nil, fmt.Errorf("%v:%d: %v", catalogPath, lineNo, err)
+			}
+
+			cur = &catalogSection{name: name, lineNo: lineNo, kv: make(map[string]string)}
+			sections = append(sections, cur)
+			continue
+		}
+
+		if cur == nil {
+			return nil, fmt.Errorf("%v:%d: key outside of a [source] section", catalogPath, lineNo)
+		}
+
+		key, value, err := parseKeyValue(ln)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%d: %v", catalogPath, lineNo, err)
+		}
+
+		if key == "column" {
+			cur.columns = append(cur.columns, value)
+		} else {
+			cur.kv[key] = value
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return sections, nil
+}
+
+// parseSectionHeader extracts "customers" out of `[source "customers"]`.
+func parseSectionHeader(ln string) (string, error) {
+	if !strings.HasPrefix(ln, "[source \"") || !strings.HasSuffix(ln, "\"]") {
+		return "", fmt.Errorf("invalid section header: '%v'", ln)
+	}
+
+	name := strings.TrimSuffix(strings.TrimPrefix(ln, "[source \""), "\"]")
+	if !IsIdent(name) {
+		return "", fmt.Errorf("invalid source name: '%v'", name)
+	}
+
+	return name, nil
+}
+
+func parseKeyValue(ln string) (string, string, error) {
+	i := strings.Index(ln, "=")
+	if i < 0 {
+		return "", "", fmt.Errorf("expected 'key = value', got '%v'", ln)
+	}
+
+	key := strings.TrimSpace(ln[:i])
+	value := strings.TrimSpace(ln[i+1:])
+	if key == "" {
+		return "", "", fmt.Errorf("expected 'key = value', got '%v'", ln)
+	}
+
+	return key, value, nil
+}
+
+// toSource turns a parsed section into a source, resolving its path
+// relative to dir (the catalog's own directory) when it isn't absolute.
+func (sec *catalogSection) toSource(dir string) (source, error) {
+	src := source{name: sec.name}
+
+	p, ok := sec.kv["path"]
+	if !ok {
+		return source{}, fmt.Errorf("source '%v' is missing a path", sec.name)
+	}
+	if !path.IsAbs(p) {
+		p = filepath.Join(dir, p)
+	}
+	src.path = p
+
+	src.format = sec.kv["format"]
+	src.compression = sec.kv["compression"]
+	src.encoding = sec.kv["encoding"]
+	if src.encoding != "" && src.encoding != "utf-8" {
+		return source{}, fmt.Errorf("source '%v': unsupported encoding '%v'", sec.name, src.encoding)
+	}
+
+	if d, ok := sec.kv["delimiter"]; ok {
+		r := []rune(d)
+		if len(r) != 1 {
+			return source{}, fmt.Errorf("source '%v': delimiter must be a single character, got '%v'", sec.name, d)
+		}
+		src.delimiter = r[0]
+	}
+
+	if sk, ok := sec.kv["skip"]; ok {
+		n, err := strconv.Atoi(sk)
+		if err != nil || n < 0 {
+			return source{}, fmt.Errorf("source '%v': invalid skip value '%v'", sec.name, sk)
+		}
+		src.skip = n
+	}
+
+	if h, ok := sec.kv["header"]; ok {
+		b, err := strconv.ParseBool(h)
+		if err != nil {
+			return source{}, fmt.Errorf("source '%v': invalid header value '%v'", sec.name, h)
+		}
+		src.noHeader = !b
+
+		if src.noHeader && len(sec.columns) == 0 {
+			return source{}, fmt.Errorf("source '%v': header = false requires explicit column declarations", sec.name)
+		}
+	}
+
+	if len(sec.columns) > 0 {
+		ot := make(ObjectType, len(sec.columns))
+		for i, c := range sec.columns {
+			name, st, err := parseHeadField(c)
+			if err != nil {
+				return source{}, fmt.Errorf("source '%v': %v", sec.name, err)
+			}
+
+			ot[i].Name = name
+			ot[i].Type = st
+		}
+		src.columns = ot
+	}
+
+	return src, nil
+}
@@ -0,0 +1,278 @@
+// Copyright (c) 2013 Ostap Cherkashin. You can use this source code
+// under the terms of the MIT License found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Loader knows how to discover the schema of a source and turn its raw lines
+// into Values. Store.Add picks an implementation by file extension; see
+// loaderFor.
+type Loader interface {
+	// Header reads as much of r as it needs to determine the relation's
+	// ObjectType (one delimited row for tabDelimLoader/csvLoader, the
+	// first record for jsonlLoader).
+	Header(r io.Reader) (ObjectType, error)
+
+	// Parse consumes raw lines from in and sends one Value per record on
+	// out. It runs concurrently, one goroutine per runtime.NumCPU(), so
+	// it must not assume lines arrive in order. stop is closed by
+	// loadBody the moment any worker fails under StrictTypes; Parse must
+	// select on it around every blocking send so the rest of the pool
+	// (and produceLines) unwind instead of leaking.
+	Parse(ot ObjectType, in <-chan line, out chan<- Value, stop <-chan struct{})
+}
+
+// errLoader is implemented by loaders that can fail a whole file under
+// StrictTypes instead of skipping the offending row.
+type errLoader interface {
+	Errs() <-chan error
+}
+
+// recordJoiner is implemented by loaders (csvLoader) whose logical records
+// can span several physical lines and so cannot be parsed by an unordered
+// worker pool without first being reassembled in order. loadBody runs
+// JoinRecords in a single dedicated goroutine ahead of the Parse pool, and
+// feeds its output to the pool instead of the raw physical lines.
+type recordJoiner interface {
+	JoinRecords(in <-chan line, out chan<- line, stop <-chan struct{})
+}
+
+// headerlessLoader is implemented by loaders (jsonlLoader) whose Header
+// derives the ObjectType from the first data record's own keys rather than
+// from a dedicated header row. loadBody must then not also discard that
+// record from the body as if it were a header line.
+type headerlessLoader interface {
+	Headerless() bool
+}
+
+var loaders = map[string]func(delimiter rune, strict bool) Loader{
+	"tsv":    func(_ rune, strict bool) Loader { return NewTabDelimLoader(strict) },
+	"csv":    func(d rune, strict bool) Loader { return NewCSVLoader(d, strict) },
+	"jsonl":  func(_ rune, strict bool) Loader { return NewJSONLLoader(strict) },
+	"ndjson": func(_ rune, strict bool) Loader { return NewJSONLLoader(strict) },
+}
+
+// loaderFor resolves src's format (explicit, or inferred from src.path's
+// extension once a compression suffix is stripped) to a registered Loader.
+// An unrecognized or absent format falls back to the original tab-delimited
+// behavior.
+func loaderFor(src source, strict bool) (Loader, error) {
+	format := src.format
+	if format == "" {
+		format = formatExt(src.path)
+	}
+
+	ctor, ok := loaders[format]
+	if !ok {
+		ctor = loaders["tsv"]
+	}
+
+	delimiter := src.delimiter
+	if delimiter == 0 {
+		delimiter = ','
+	}
+
+	return ctor(delimiter, strict), nil
+}
+
+// formatExt returns fileName's extension with any .gz/.zst compression
+// suffix stripped first, so "dump.csv.gz" resolves to "csv".
+func formatExt(fileName string) string {
+	name := strings.TrimSuffix(fileName, ".gz")
+	name = strings.TrimSuffix(name, ".zst")
+
+	ext := ""
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		ext = name[dot+1:]
+	}
+
+	return ext
+}
+
+// decompressCloser pairs a decompressor with the underlying file it reads
+// from, so Close releases both: a zstd.Decoder in particular holds
+// background goroutines and buffers that are only freed by its own Close,
+// not the file's.
+type decompressCloser struct {
+	io.Reader
+	decomp io.Closer
+	file   *os.File
+}
+
+func (c *decompressCloser) Close() error {
+	decompErr := c.decomp.Close()
+	fileErr := c.file.Close()
+	if decompErr != nil {
+		return decompErr
+	}
+
+	return fileErr
+}
+
+// openSource opens src.path and transparently wraps it in a decompressor,
+// either because src.compression says so or because the name ends in .gz
+// or .zst. Callers must close the returned io.ReadCloser.
+func openSource(src source) (io.ReadCloser, error) {
+	file, err := os.Open(src.path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case src.compression == "gzip" || (src.compression == "" && strings.HasSuffix(src.path, ".gz")):
+		r, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &decompressCloser{Reader: r, decomp: r, file: file}, nil
+	case src.compression == "zstd" || (src.compression == "" && strings.HasSuffix(src.path, ".zst")):
+		r, err := zstd.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, err
+		}
+		return &decompressCloser{Reader: r, decomp: closerFunc(func() error { r.Close(); return nil }), file: file}, nil
+	default:
+		return file, nil
+	}
+}
+
+// closerFunc adapts a plain func() error to io.Closer, for decompressors
+// (zstd.Decoder) whose Close doesn't itself return an error.
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
+
+// loadBody drives the concurrent worker pool: one goroutine per
+// runtime.NumCPU() reads raw lines off a shared channel and hands them to
+// ldr.Parse, same pipeline shape as the original tab-delimited reader.
+//
+// stop is closed the instant any worker reports a StrictTypes failure, and
+// produceLines plus every Parse goroutine select on it around their
+// blocking sends, so a strict failure unwinds the whole pipeline instead of
+// leaving the producer and the other workers parked on a full buffered
+// channel nobody is draining anymore.
+func loadBody(ldr Loader, ot ObjectType, r io.Reader, src source) (List, error) {
+	stop := make(chan struct{})
+
+	hasHeader := true
+	if hl, ok := ldr.(headerlessLoader); ok {
+		hasHeader = !hl.Headerless()
+	}
+
+	lines := make(chan line, 1024)
+	go produceLines(r, src.headerLines(hasHeader), lines, stop)
+
+	parseLines := lines
+	if rj, ok := ldr.(recordJoiner); ok {
+		joined := make(chan line, 1024)
+		go rj.JoinRecords(lines, joined, stop)
+		parseLines = joined
+	}
+
+	tuples := make(Body, 1024)
+	ctl := make(chan int)
+
+	workers := runtime.NumCPU()
+	for i := 0; i < workers; i++ {
+		go func() {
+			ldr.Parse(ot, parseLines, tuples, stop)
+			ctl <- 1
+		}()
+	}
+	go func() {
+		for i := 0; i < workers; i++ {
+			<-ctl
+		}
+		close(tuples)
+	}()
+
+	var errs <-chan error
+	if el, ok := ldr.(errLoader); ok {
+		errs = el.Errs()
+	}
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	list := make(List, 0)
+	count := 0
+	for {
+		select {
+		case <-ticker.C:
+			log.Printf("loading %v (%d tuples)", src.path, count)
+		case err := <-errs:
+			close(stop)
+			return nil, err
+		case t, ok := <-tuples:
+			if !ok {
+				// A worker's strict failure may still be pending when
+				// tuples closes; the select above picks between ready
+				// cases at random, so check errs once more.
+				select {
+				case err := <-errs:
+					return nil, err
+				default:
+				}
+				return list, nil
+			}
+
+			list = append(list, t)
+			count++
+		}
+	}
+}
+
+// produceLines reads r's contents one physical line at a time, discarding
+// the first headerLines of them (the header row itself, plus any extra
+// rows a catalog entry asked to skip), and feeds the rest to parsers. It
+// gives up and closes lines the moment stop fires, rather than blocking
+// forever on a send nobody is going to receive.
+func produceLines(r io.Reader, headerLines int, lines chan<- line, stop <-chan struct{}) {
+	defer close(lines)
+
+	buf := bufio.NewReader(r)
+
+	for lineNo := 0; ; lineNo++ {
+		lineStr, err := buf.ReadString('\n')
+		if len(lineStr) == 0 {
+			break
+		}
+		if lineNo < headerLines {
+			if err != nil {
+				break
+			}
+			continue
+		}
+
+		select {
+		case lines <- line{lineNo, lineStr}:
+		case <-stop:
+			return
+		}
+		if err != nil {
+			break
+		}
+	}
+}
+
+// chompLine strips a single trailing newline (and, for CRLF sources, the
+// preceding carriage return) from a raw line.
+func chompLine(s string) string {
+	s = strings.TrimSuffix(s, "\n")
+	s = strings.TrimSuffix(s, "\r")
+	return s
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2013 Ostap Cherkashin. You can use this source code
+// under the terms of the MIT License found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// csvLoader reads RFC 4180 CSV: quoted fields, escaped quotes ("") and
+// fields that embed the record separator all parse correctly.
+type csvLoader struct {
+	comma  rune
+	strict bool
+	errs   chan error
+}
+
+func NewCSVLoader(comma rune, strict bool) *csvLoader {
+	return &csvLoader{comma: comma, strict: strict, errs: make(chan error, 1)}
+}
+
+func (l *csvLoader) Errs() <-chan error {
+	return l.errs
+}
+
+func (l *csvLoader) Header(r io.Reader) (ObjectType, error) {
+	cr := csv.NewReader(r)
+	cr.Comma = l.comma
+
+	fields, err := cr.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(ObjectType, len(fields))
+	for i, f := range fields {
+		name, st, err := parseHeadField(strings.TrimSpace(f))
+		if err != nil {
+			return nil, err
+		}
+
+		res[i].Name = name
+		res[i].Type = st
+	}
+
+	return res, nil
+}
+
+// JoinRecords re-assembles quoted fields that embed a record separator:
+// it accumulates raw physical lines until they contain an even number of
+// '"' characters, which is exactly the point at which no quoted field is
+// left open, and emits the joined result as a single logical record. It
+// must run single-threaded against an ordered stream of physical lines,
+// since quote parity is state carried across lines; loadBody runs it in
+// one dedicated goroutine ahead of the Parse worker pool.
+func (l *csvLoader) JoinRecords(in <-chan line, out chan<- line, stop <-chan struct{}) {
+	defer close(out)
+
+	var buf strings.Builder
+	quotes := 0
+	lineNo := 0
+
+	for ln := range in {
+		if lineNo == 0 {
+			lineNo = ln.lineNo
+		}
+
+		buf.WriteString(ln.lineStr)
+		quotes += strings.Count(ln.lineStr, `"`)
+		if quotes%2 != 0 {
+			continue
+		}
+
+		select {
+		case out <- line{lineNo, buf.String()}:
+		case <-stop:
+			return
+		}
+		buf.Reset()
+		quotes = 0
+		lineNo = 0
+	}
+
+	if buf.Len() > 0 {
+		log.Printf("line %d: unterminated quoted field, discarding trailing record", lineNo)
+	}
+}
+
+// Parse decodes one already-joined logical record per line received: by
+// the time a line reaches here, JoinRecords has already merged any
+// continuation lines a quoted field embedded, so workers can run
+// concurrently and in any order.
+func (l *csvLoader) Parse(ot ObjectType, in <-chan line, out chan<- Value, stop <-chan struct{}) {
+	for ln := range in {
+		if !l.parseRecord(ln.lineNo, ln.lineStr, ot, out, stop) {
+			return
+		}
+	}
+}
+
+// parseRecord returns false when a field failed to coerce under strict mode
+// or when stop fired while it was trying to deliver a row, telling Parse's
+// caller loop to unwind immediately.
+func (l *csvLoader) parseRecord(lineNo int, record string, ot ObjectType, out chan<- Value, stop <-chan struct{}) bool {
+	cr := csv.NewReader(strings.NewReader(record))
+	cr.Comma = l.comma
+
+	fields, err := cr.Read()
+	if err != nil {
+		log.Printf("line %d: malformed record: %v (skipping row)", lineNo, err)
+		return true
+	}
+
+	if len(fields) > len(ot) {
+		log.Printf("line %d: truncating object (-%d fields)", lineNo, len(fields)-len(ot))
+		fields = fields[:len(ot)]
+	} else if len(fields) < len(ot) {
+		log.Printf("line %d: missing fields, appending blank strings", lineNo)
+		for len(fields) < len(ot) {
+			fields = append(fields, "")
+		}
+	}
+
+	obj := make(Object, len(ot))
+	for i, s := range fields {
+		val, _, err := coerce(s, ot[i].Type)
+		if err != nil {
+			if l.strict {
+				l.fail(lineNo, fmt.Errorf("field '%v': %v", ot[i].Name, err))
+				return false
+			}
+
+			log.Printf("line %d: field '%v': %v (skipping row)", lineNo, ot[i].Name, err)
+			return true
+		}
+
+		obj[i] = val
+	}
+
+	select {
+	case out <- obj:
+	case <-stop:
+		return false
+	}
+
+	return true
+}
+
+func (l *csvLoader) fail(lineNo int, err error) {
+	select {
+	case l.errs <- fmt.Errorf("line %d: %v", lineNo, err):
+	default:
+	}
+}
@@ -0,0 +1,65 @@
+// Copyright (c) 2013 Ostap Cherkashin. You can use this source code
+// under the terms of the MIT License found in the LICENSE file.
+
+package main
+
+import "testing"
+
+// TestCSVJoinRecordsMultiLine verifies that a quoted field embedding a
+// record separator is reassembled into a single logical record before it
+// reaches Parse. This is the reassembly step that must run single-threaded,
+// ahead of the unordered Parse worker pool: feeding raw physical lines
+// straight into Parse (as the pre-fix code did) corrupts quote-parity
+// tracking because consecutive lines land on different goroutines.
+func TestCSVJoinRecordsMultiLine(t *testing.T) {
+	l := NewCSVLoader(',', false)
+
+	in := make(chan line, 8)
+	out := make(chan line, 8)
+	stop := make(chan struct{})
+
+	in <- line{1, "1,\"hello\n"}
+	in <- line{2, "world\",3\n"}
+	in <- line{3, "4,plain,6\n"}
+	close(in)
+
+	l.JoinRecords(in, out, stop)
+
+	var got []line
+	for rec := range out {
+		got = append(got, rec)
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("expected 2 joined records, got %d: %v", len(got), got)
+	}
+
+	if got[0].lineNo != 1 || got[0].lineStr != "1,\"hello\nworld\",3\n" {
+		t.Errorf("record 1 not reassembled correctly: %+v", got[0])
+	}
+	if got[1].lineNo != 3 || got[1].lineStr != "4,plain,6\n" {
+		t.Errorf("record 2 not reassembled correctly: %+v", got[1])
+	}
+}
+
+// TestCSVJoinRecordsStop verifies that JoinRecords gives up and closes out
+// as soon as stop fires, rather than blocking forever trying to deliver a
+// record nobody will read.
+func TestCSVJoinRecordsStop(t *testing.T) {
+	l := NewCSVLoader(',', false)
+
+	in := make(chan line, 1)
+	out := make(chan line) // unbuffered: a send blocks until stop wins
+	stop := make(chan struct{})
+
+	in <- line{1, "1,2,3\n"}
+	close(stop)
+
+	done := make(chan struct{})
+	go func() {
+		l.JoinRecords(in, out, stop)
+		close(done)
+	}()
+
+	<-done
+}
@@ -0,0 +1,144 @@
+// Copyright (c) 2013 Ostap Cherkashin. You can use this source code
+// under the terms of the MIT License found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+)
+
+// jsonlLoader reads newline-delimited JSON objects. The keys of the first
+// object become the schema (sorted for a stable column order); subsequent
+// records are coerced against it, with a missing key padded with a blank
+// string the same way short TSV rows are.
+type jsonlLoader struct {
+	strict bool
+	errs   chan error
+}
+
+func NewJSONLLoader(strict bool) *jsonlLoader {
+	return &jsonlLoader{strict: strict, errs: make(chan error, 1)}
+}
+
+func (l *jsonlLoader) Errs() <-chan error {
+	return l.errs
+}
+
+// Headerless reports that jsonlLoader has no dedicated header row: Header
+// derives the schema from the first record's own keys, and that record is
+// real data that must still reach Parse.
+func (l *jsonlLoader) Headerless() bool {
+	return true
+}
+
+func (l *jsonlLoader) Header(r io.Reader) (ObjectType, error) {
+	var first map[string]json.RawMessage
+	if err := json.NewDecoder(r).Decode(&first); err != nil {
+		return nil, fmt.Errorf("reading first record: %v", err)
+	}
+
+	names := make([]string, 0, len(first))
+	for k := range first {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	res := make(ObjectType, len(names))
+	for i, n := range names {
+		if !IsIdent(n) {
+			return nil, fmt.Errorf("invalid field name: '%v'", n)
+		}
+
+		res[i].Name = n
+		res[i].Type = ScalarAuto
+	}
+
+	return res, nil
+}
+
+func (l *jsonlLoader) Parse(ot ObjectType, in <-chan line, out chan<- Value, stop <-chan struct{}) {
+	for ln := range in {
+		var rec map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(chompLine(ln.lineStr)), &rec); err != nil {
+			log.Printf("line %d: %v (skipping row)", ln.lineNo, err)
+			continue
+		}
+
+		obj := make(Object, len(ot))
+		skip := false
+		for i, f := range ot {
+			raw, present := rec[f.Name]
+			if !present {
+				log.Printf("line %d: missing field '%v', using blank string", ln.lineNo, f.Name)
+				obj[i] = String("")
+				continue
+			}
+
+			val, err := coerceJSON(raw, f.Type)
+			if err != nil {
+				if l.strict {
+					l.fail(ln.lineNo, fmt.Errorf("field '%v': %v", f.Name, err))
+					return
+				}
+
+				log.Printf("line %d: field '%v': %v (skipping row)", ln.lineNo, f.Name, err)
+				skip = true
+				break
+			}
+
+			obj[i] = val
+		}
+
+		if skip {
+			continue
+		}
+
+		select {
+		case out <- obj:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (l *jsonlLoader) fail(lineNo int, err error) {
+	select {
+	case l.errs <- fmt.Errorf("line %d: %v", lineNo, err):
+	default:
+	}
+}
+
+// coerceJSON maps a JSON scalar onto the Value demanded by st. Strings,
+// numbers and bools are routed through the same st-driven coerce() the
+// delimited loaders use, by formatting numbers and bools back to text
+// first, so a declared type is validated (and, for date/datetime, actually
+// parsed into a Time) regardless of which of those kinds carried the value
+// on the wire. A JSON null becomes a blank string without being checked
+// against st, and arrays and objects are rejected.
+func coerceJSON(raw json.RawMessage, st ScalarType) (Value, error) {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, err
+	}
+
+	switch t := v.(type) {
+	case string:
+		val, _, err := coerce(t, st)
+		return val, err
+	case float64:
+		val, _, err := coerce(strconv.FormatFloat(t, 'f', -1, 64), st)
+		return val, err
+	case bool:
+		val, _, err := coerce(strconv.FormatBool(t), st)
+		return val, err
+	case nil:
+		return String(""), nil
+	default:
+		return nil, fmt.Errorf("unsupported JSON value for a scalar field")
+	}
+}
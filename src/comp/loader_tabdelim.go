@@ -0,0 +1,99 @@
+// Copyright (c) 2013 Ostap Cherkashin. You can use this source code
+// under the terms of the MIT License found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+)
+
+// tabDelimLoader is the original, tab-separated reader, now expressed as a
+// Loader. It is also the fallback for any file whose extension is not
+// otherwise registered.
+type tabDelimLoader struct {
+	strict bool
+	errs   chan error
+}
+
+func NewTabDelimLoader(strict bool) *tabDelimLoader {
+	return &tabDelimLoader{strict: strict, errs: make(chan error, 1)}
+}
+
+func (l *tabDelimLoader) Errs() <-chan error {
+	return l.errs
+}
+
+func (l *tabDelimLoader) Header(r io.Reader) (ObjectType, error) {
+	str, err := bufio.NewReader(r).ReadString('\n')
+	if err != nil && str == "" {
+		return nil, err
+	}
+
+	fields := strings.Split(str, "\t")
+	res := make(ObjectType, len(fields))
+	for i, f := range fields {
+		name, st, err := parseHeadField(strings.Trim(f, " \r\n"))
+		if err != nil {
+			return nil, err
+		}
+
+		res[i].Name = name
+		res[i].Type = st
+	}
+
+	return res, nil
+}
+
+func (l *tabDelimLoader) Parse(ot ObjectType, in <-chan line, out chan<- Value, stop <-chan struct{}) {
+	for ln := range in {
+		fields := strings.Split(chompLine(ln.lineStr), "\t")
+		if len(fields) > len(ot) {
+			log.Printf("line %d: truncating object (-%d fields)", ln.lineNo, len(fields)-len(ot))
+			fields = fields[:len(ot)]
+		} else if len(fields) < len(ot) {
+			log.Printf("line %d: missing fields, appending blank strings", ln.lineNo)
+			for len(fields) < len(ot) {
+				fields = append(fields, "")
+			}
+		}
+
+		obj := make(Object, len(ot))
+		skip := false
+		for i, s := range fields {
+			val, _, err := coerce(s, ot[i].Type)
+			if err != nil {
+				if l.strict {
+					l.fail(ln.lineNo, fmt.Errorf("field '%v': %v", ot[i].Name, err))
+					return
+				}
+
+				log.Printf("line %d: field '%v': %v (skipping row)", ln.lineNo, ot[i].Name, err)
+				skip = true
+				break
+			}
+
+			obj[i] = val
+		}
+
+		if skip {
+			continue
+		}
+
+		select {
+		case out <- obj:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (l *tabDelimLoader) fail(lineNo int, err error) {
+	select {
+	case l.errs <- fmt.Errorf("line %d: %v", lineNo, err):
+	default:
+	}
+}
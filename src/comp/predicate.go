@@ -0,0 +1,576 @@
+// Copyright (c) 2013 Ostap Cherkashin. You can use this source code
+// under the terms of the MIT License found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// AddFiltered loads fileName the same way Add does, but drops every row
+// that does not match predicate before it is ever appended to the
+// relation's List. predicate is a small expression grammar:
+//
+//	field op value [and|or field op value ...]
+//
+// with op one of = != < <= > >= ~ !~ (~ and !~ being regex match) and
+// parentheses for grouping, e.g. `(status = "open" or status = "new") and
+// priority >= 3`. A predicate that compares a field against an operator it
+// cannot support (ordering on a string field, a malformed regex, ...) is
+// rejected up front rather than silently dropping every row.
+func (s Store) AddFiltered(fileName string, predicate string) error {
+	name := relName(fileName)
+	if !IsIdent(name) {
+		return fmt.Errorf("invalid file name: '%v' cannot be used as an identifier (ignoring)", name)
+	}
+
+	src := source{name: name, path: fileName}
+	ot, list, err := s.loadFiltered(src, predicate)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.types[name] = ot
+	s.lists[name] = list
+	s.sources[name] = src
+	s.mu.Unlock()
+
+	log.Printf("stored %v (recs %v, filtered)", name, len(list))
+	return nil
+}
+
+func (s Store) loadFiltered(src source, predicate string) (ObjectType, List, error) {
+	ldr, err := loaderFor(src, s.StrictTypes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load %v: %v", src.path, err)
+	}
+
+	ot, err := readHead(ldr, src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load %v: %v", src.path, err)
+	}
+
+	pred, err := parsePredicate(ot, predicate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid predicate for %v: %v", src.path, err)
+	}
+
+	list, err := readBody(&filterLoader{inner: ldr, pred: pred}, ot, src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load %v: %v", src.path, err)
+	}
+
+	return ot, list, nil
+}
+
+// filterLoader wraps another Loader and discards any Object its predicate
+// rejects before it reaches the shared tuples channel, so a filtered row
+// never gets appended to the List in loadBody.
+type filterLoader struct {
+	inner Loader
+	pred  predNode
+}
+
+func (f *filterLoader) Header(r io.Reader) (ObjectType, error) {
+	return f.inner.Header(r)
+}
+
+func (f *filterLoader) Parse(ot ObjectType, in <-chan line, out chan<- Value, stop <-chan struct{}) {
+	raw := make(chan Value, 64)
+	go func() {
+		f.inner.Parse(ot, in, raw, stop)
+		close(raw)
+	}()
+
+	for v := range raw {
+		obj, ok := v.(Object)
+		if !ok {
+			select {
+			case out <- v:
+			case <-stop:
+				return
+			}
+			continue
+		}
+
+		keep, err := f.pred.eval(obj)
+		if err != nil {
+			log.Printf("filter: %v (dropping row)", err)
+			continue
+		}
+
+		if keep {
+			select {
+			case out <- v:
+			case <-stop:
+				return
+			}
+		}
+	}
+}
+
+func (f *filterLoader) Errs() <-chan error {
+	if el, ok := f.inner.(errLoader); ok {
+		return el.Errs()
+	}
+
+	return nil
+}
+
+// Headerless forwards to the wrapped Loader, so filtering a headerless
+// format like JSONL doesn't regain a phantom header row to discard.
+func (f *filterLoader) Headerless() bool {
+	if hl, ok := f.inner.(headerlessLoader); ok {
+		return hl.Headerless()
+	}
+
+	return false
+}
+
+// JoinRecords forwards to the wrapped Loader's own reassembly stage when it
+// has one (e.g. csvLoader), so wrapping a multi-line-record format in
+// AddFiltered doesn't silently lose that reassembly.
+func (f *filterLoader) JoinRecords(in <-chan line, out chan<- line, stop <-chan struct{}) {
+	if rj, ok := f.inner.(recordJoiner); ok {
+		rj.JoinRecords(in, out, stop)
+		return
+	}
+
+	defer close(out)
+	for ln := range in {
+		select {
+		case out <- ln:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// predNode is one node of a compiled predicate: a comparison, or an
+// and/or combination of two others.
+type predNode interface {
+	eval(obj Object) (bool, error)
+}
+
+type andNode struct{ left, right predNode }
+
+func (n andNode) eval(obj Object) (bool, error) {
+	ok, err := n.left.eval(obj)
+	if err != nil || !ok {
+		return false, err
+	}
+
+	return n.right.eval(obj)
+}
+
+type orNode struct{ left, right predNode }
+
+func (n orNode) eval(obj Object) (bool, error) {
+	ok, err := n.left.eval(obj)
+	if err != nil || ok {
+		return ok, err
+	}
+
+	return n.right.eval(obj)
+}
+
+// cmpNode evaluates "field op value" against one column of an Object. Only
+// the literal form that matches the field's declared ScalarType is
+// populated; the rest stay zero. The one exception is = or != on a string
+// or auto field with an unquoted numeric literal, which gets both its
+// string and its number form.
+type cmpNode struct {
+	idx int
+	op  string
+
+	str     string
+	useNum  bool
+	num     float64
+	useBool bool
+	boolVal bool
+	useTime bool
+	timeVal time.Time
+	re      *regexp.Regexp
+}
+
+func (n *cmpNode) eval(obj Object) (bool, error) {
+	v := obj[n.idx]
+
+	switch n.op {
+	case "~", "!~":
+		s, ok := v.(String)
+		matched := ok && n.re.MatchString(string(s))
+		if n.op == "!~" {
+			return !matched, nil
+		}
+		return matched, nil
+
+	case "<", "<=", ">", ">=":
+		switch t := v.(type) {
+		case Number:
+			if !n.useNum {
+				return false, nil
+			}
+			return orderNum(float64(t), n.num, n.op), nil
+		case Time:
+			if !n.useTime {
+				return false, nil
+			}
+			return orderTime(time.Time(t), n.timeVal, n.op), nil
+		default:
+			return false, nil
+		}
+
+	default: // "=", "!="
+		eq := n.equals(v)
+		if n.op == "!=" {
+			return !eq, nil
+		}
+		return eq, nil
+	}
+}
+
+func (n *cmpNode) equals(v Value) bool {
+	switch t := v.(type) {
+	case String:
+		return string(t) == n.str
+	case Number:
+		return n.useNum && float64(t) == n.num
+	case Bool:
+		return n.useBool && bool(t) == n.boolVal
+	case Time:
+		return n.useTime && time.Time(t).Equal(n.timeVal)
+	}
+
+	return false
+}
+
+func orderNum(a, b float64, op string) bool {
+	switch op {
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	default: // ">="
+		return a >= b
+	}
+}
+
+func orderTime(a, b time.Time, op string) bool {
+	switch op {
+	case "<":
+		return a.Before(b)
+	case "<=":
+		return a.Before(b) || a.Equal(b)
+	case ">":
+		return a.After(b)
+	default: // ">="
+		return a.After(b) || a.Equal(b)
+	}
+}
+
+// token is one lexical unit of a predicate string.
+type token struct {
+	kind string // "word", "string", "op", "lparen", "rparen"
+	text string
+}
+
+// parsePredicate compiles predicate against ot, resolving every field
+// reference to its column position and checking its operator against the
+// field's declared ScalarType up front, so a type mismatch is a load-time
+// error rather than a row that silently never matches.
+func parsePredicate(ot ObjectType, predicate string) (predNode, error) {
+	toks, err := lexPredicate(predicate)
+	if err != nil {
+		return nil, err
+	}
+	if len(toks) == 0 {
+		return nil, fmt.Errorf("empty predicate")
+	}
+
+	p := &predParser{toks: toks, ot: ot}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.toks) {
+		return nil, fmt.Errorf("unexpected token '%v'", p.toks[p.pos].text)
+	}
+
+	return node, nil
+}
+
+type predParser struct {
+	toks []token
+	pos  int
+	ot   ObjectType
+}
+
+func (p *predParser) parseOr() (predNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *predParser) parseAnd() (predNode, error) {
+	left, err := p.parseFactor()
+	if err != nil {
+		return nil, err
+	}
+
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseFactor()
+		if err != nil {
+			return nil, err
+		}
+		left = andNode{left, right}
+	}
+
+	return left, nil
+}
+
+func (p *predParser) parseFactor() (predNode, error) {
+	if p.pos >= len(p.toks) {
+		return nil, fmt.Errorf("unexpected end of predicate")
+	}
+
+	if p.toks[p.pos].kind == "lparen" {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos >= len(p.toks) || p.toks[p.pos].kind != "rparen" {
+			return nil, fmt.Errorf("expected ')'")
+		}
+		p.pos++
+		return node, nil
+	}
+
+	return p.parseComparison()
+}
+
+func (p *predParser) parseComparison() (predNode, error) {
+	if p.pos+2 >= len(p.toks) {
+		return nil, fmt.Errorf("incomplete comparison")
+	}
+
+	fieldTok, opTok, valTok := p.toks[p.pos], p.toks[p.pos+1], p.toks[p.pos+2]
+	if fieldTok.kind != "word" {
+		return nil, fmt.Errorf("expected field name, got '%v'", fieldTok.text)
+	}
+	if opTok.kind != "op" {
+		return nil, fmt.Errorf("expected comparison operator, got '%v'", opTok.text)
+	}
+	p.pos += 3
+
+	return compileCmp(p.ot, fieldTok.text, opTok.text, valTok)
+}
+
+func (p *predParser) peekKeyword(kw string) bool {
+	return p.pos < len(p.toks) && p.toks[p.pos].kind == "word" && strings.EqualFold(p.toks[p.pos].text, kw)
+}
+
+// compileCmp resolves field against ot and builds the cmpNode literal that
+// matches its declared ScalarType, rejecting operator/type combinations
+// that can never make sense (e.g. '<' on a string field).
+func compileCmp(ot ObjectType, field, op string, valTok token) (predNode, error) {
+	idx := -1
+	for i, f := range ot {
+		if f.Name == field {
+			idx = i
+			break
+		}
+	}
+	if idx < 0 {
+		return nil, fmt.Errorf("unknown field '%v'", field)
+	}
+
+	ft := ot[idx].Type
+	n := &cmpNode{idx: idx, op: op}
+
+	switch op {
+	case "~", "!~":
+		if ft != ScalarString && ft != ScalarAuto {
+			return nil, fmt.Errorf("operator '%v' is not valid on field '%v' (%v)", op, field, scalarName(ft))
+		}
+
+		re, err := regexp.Compile(valTok.text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex for field '%v': %v", field, err)
+		}
+		n.re = re
+		return n, nil
+
+	case "<", "<=", ">", ">=":
+		switch ft {
+		case ScalarString, ScalarBool:
+			return nil, fmt.Errorf("operator '%v' is not valid on field '%v' (%v)", op, field, scalarName(ft))
+		case ScalarDate, ScalarDateTime:
+			t, err := parseTime(valTok.text)
+			if err != nil {
+				return nil, fmt.Errorf("field '%v': %v", field, err)
+			}
+			n.useTime = true
+			n.timeVal = t
+		default: // ScalarAuto, ScalarInt, ScalarFloat
+			f, err := strconv.ParseFloat(valTok.text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("field '%v': '%v' is not a number", field, valTok.text)
+			}
+			n.useNum = true
+			n.num = f
+		}
+		return n, nil
+
+	case "=", "!=":
+		switch ft {
+		case ScalarBool:
+			b, err := strconv.ParseBool(valTok.text)
+			if err != nil {
+				return nil, fmt.Errorf("field '%v': '%v' is not a bool", field, valTok.text)
+			}
+			n.useBool = true
+			n.boolVal = b
+		case ScalarInt, ScalarFloat:
+			f, err := strconv.ParseFloat(valTok.text, 64)
+			if err != nil {
+				return nil, fmt.Errorf("field '%v': '%v' is not a number", field, valTok.text)
+			}
+			n.useNum = true
+			n.num = f
+		case ScalarDate, ScalarDateTime:
+			t, err := parseTime(valTok.text)
+			if err != nil {
+				return nil, fmt.Errorf("field '%v': %v", field, err)
+			}
+			n.useTime = true
+			n.timeVal = t
+		default: // ScalarString, ScalarAuto
+			n.str = valTok.text
+			if valTok.kind != "string" {
+				if f, err := strconv.ParseFloat(valTok.text, 64); err == nil {
+					n.useNum = true
+					n.num = f
+				}
+			}
+		}
+		return n, nil
+
+	default:
+		return nil, fmt.Errorf("unknown operator '%v'", op)
+	}
+}
+
+func scalarName(st ScalarType) string {
+	for name, v := range scalarNames {
+		if v == st {
+			return name
+		}
+	}
+
+	return "auto"
+}
+
+// lexPredicate tokenizes a predicate string into words, quoted strings,
+// operators and parentheses.
+func lexPredicate(s string) ([]token, error) {
+	var toks []token
+
+	i, n := 0, len(s)
+	for i < n {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '(':
+			toks = append(toks, token{"lparen", "("})
+			i++
+		case c == ')':
+			toks = append(toks, token{"rparen", ")"})
+			i++
+		case c == '"':
+			lit, width, err := lexString(s[i:])
+			if err != nil {
+				return nil, err
+			}
+			toks = append(toks, token{"string", lit})
+			i += width
+		case strings.IndexByte("=!<>~", c) >= 0:
+			op, width := lexOp(s[i:])
+			if op == "" {
+				return nil, fmt.Errorf("invalid operator at %q", s[i:])
+			}
+			toks = append(toks, token{"op", op})
+			i += width
+		default:
+			j := i
+			for j < n && strings.IndexByte(" \t\r\n()=!<>~\"", s[j]) < 0 {
+				j++
+			}
+			toks = append(toks, token{"word", s[i:j]})
+			i = j
+		}
+	}
+
+	return toks, nil
+}
+
+// lexString reads a "..." literal starting at s[0]. Only \" and \\ are
+// escape sequences; any other backslash (e.g. the \d, \s, \w of a ~ or !~
+// regex operand) is passed through untouched so the resulting string still
+// compiles as the regex the author wrote.
+func lexString(s string) (string, int, error) {
+	var sb strings.Builder
+
+	j := 1
+	for j < len(s) && s[j] != '"' {
+		if s[j] == '\\' && j+1 < len(s) && (s[j+1] == '"' || s[j+1] == '\\') {
+			sb.WriteByte(s[j+1])
+			j += 2
+			continue
+		}
+		sb.WriteByte(s[j])
+		j++
+	}
+	if j >= len(s) {
+		return "", 0, fmt.Errorf("unterminated string literal")
+	}
+
+	return sb.String(), j + 1, nil
+}
+
+var twoCharOps = map[string]bool{"!=": true, "<=": true, ">=": true, "!~": true}
+
+func lexOp(s string) (string, int) {
+	if len(s) >= 2 && twoCharOps[s[:2]] {
+		return s[:2], 2
+	}
+	if len(s) >= 1 && strings.IndexByte("=<>~", s[0]) >= 0 {
+		return string(s[0]), 1
+	}
+
+	return "", 0
+}
@@ -0,0 +1,33 @@
+// Copyright (c) 2013 Ostap Cherkashin. You can use this source code
+// under the terms of the MIT License found in the LICENSE file.
+
+package main
+
+import "testing"
+
+// TestLexStringRegexEscapes verifies that lexString only unescapes \" and
+// \\, leaving every other backslash sequence untouched. A ~/!~ regex
+// operand is read through this same lexer, so a class like \d previously
+// lost its backslash silently (compiling to "d" instead of "\d") with no
+// error raised.
+func TestLexStringRegexEscapes(t *testing.T) {
+	cases := []struct {
+		in   string
+		want string
+	}{
+		{`"^A\d+$"`, `^A\d+$`},
+		{`"\s\w\."`, `\s\w\.`},
+		{`"a\"b"`, `a"b`},
+		{`"a\\b"`, `a\b`},
+	}
+
+	for _, c := range cases {
+		got, _, err := lexString(c.in)
+		if err != nil {
+			t.Fatalf("lexString(%q): %v", c.in, err)
+		}
+		if got != c.want {
+			t.Errorf("lexString(%q) = %q, want %q", c.in, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,17 @@
+// Copyright (c) 2013 Ostap Cherkashin. You can use this source code
+// under the terms of the MIT License found in the LICENSE file.
+
+package main
+
+import "time"
+
+// Bool is the Value kind produced by a field declared "name:bool".
+type Bool bool
+
+// Time is the Value kind produced by a field declared "name:date" or
+// "name:datetime". It wraps the instant parsed by one of dateLayouts.
+//
+// Time values are produced by coerce and compared by load-time predicates;
+// no builtin function (FuncTrunc, FuncDist, ...) accepts a Time, and there
+// are no date-oriented builtins.
+type Time time.Time
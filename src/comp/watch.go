@@ -0,0 +1,179 @@
+// Copyright (c) 2013 Ostap Cherkashin. You can use this source code
+// under the terms of the MIT License found in the LICENSE file.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// ReloadEvent reports the outcome of one Reload, whether triggered
+// explicitly or by Watch noticing a change on disk.
+type ReloadEvent struct {
+	Name     string
+	OldCount int
+	NewCount int
+	Err      error
+	Duration time.Duration
+}
+
+// Events returns the channel Reload publishes a ReloadEvent to after every
+// attempt. It is buffered; if the caller falls behind and the buffer is
+// full, the new event is dropped and logged rather than blocking the reload.
+func (s Store) Events() <-chan ReloadEvent {
+	return s.events
+}
+
+// Reload re-parses the file backing name into a new List and swaps it in
+// under s.mu, leaving any List already handed out by a prior Decls() call
+// untouched: readBody always builds a fresh slice, it never mutates one in
+// place.
+func (s Store) Reload(name string) error {
+	s.mu.RLock()
+	src, ok := s.sources[name]
+	oldCount := len(s.lists[name])
+	s.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("reload: unknown relation '%v'", name)
+	}
+
+	start := time.Now()
+	ot, list, err := s.load(src)
+	ev := ReloadEvent{Name: name, OldCount: oldCount, Duration: time.Since(start), Err: err}
+
+	if err == nil {
+		ev.NewCount = len(list)
+
+		s.mu.Lock()
+		s.types[name] = ot
+		s.lists[name] = list
+		s.mu.Unlock()
+	}
+
+	s.emit(ev)
+	return err
+}
+
+func (s Store) load(src source) (ObjectType, List, error) {
+	ldr, err := loaderFor(src, s.StrictTypes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load %v: %v", src.path, err)
+	}
+
+	ot, err := readHead(ldr, src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load %v: %v", src.path, err)
+	}
+
+	list, err := readBody(ldr, ot, src)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load %v: %v", src.path, err)
+	}
+
+	return ot, list, nil
+}
+
+func (s Store) emit(ev ReloadEvent) {
+	select {
+	case s.events <- ev:
+	default:
+		log.Printf("reload: dropping event for %v, no listener keeping up", ev.Name)
+	}
+}
+
+// Watch observes every registered source's directory with fsnotify and
+// calls Reload on the affected relation whenever its file is written,
+// created or renamed (covering the common "write a temp file, rename over
+// the original" update pattern). It returns once the watcher is set up;
+// the watch loop itself runs in the background until ctx is done.
+func (s Store) Watch(ctx context.Context) error {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	byPath, dirs, err := s.watchTargets()
+	if err != nil {
+		w.Close()
+		return err
+	}
+
+	for dir := range dirs {
+		if err := w.Add(dir); err != nil {
+			w.Close()
+			return fmt.Errorf("watch %v: %v", dir, err)
+		}
+	}
+
+	go s.watchLoop(ctx, w, byPath)
+	return nil
+}
+
+// watchTargets resolves every registered source's absolute path and the
+// set of directories that cover them.
+func (s Store) watchTargets() (map[string]string, map[string]bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byPath := make(map[string]string, len(s.sources))
+	dirs := make(map[string]bool)
+	for name, src := range s.sources {
+		abs, err := filepath.Abs(src.path)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		byPath[abs] = name
+		dirs[filepath.Dir(abs)] = true
+	}
+
+	return byPath, dirs, nil
+}
+
+func (s Store) watchLoop(ctx context.Context, w *fsnotify.Watcher, byPath map[string]string) {
+	defer w.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			s.handleEvent(ev, byPath)
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("watch: %v", err)
+		}
+	}
+}
+
+func (s Store) handleEvent(ev fsnotify.Event, byPath map[string]string) {
+	if ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+		return
+	}
+
+	abs, err := filepath.Abs(ev.Name)
+	if err != nil {
+		return
+	}
+
+	name, ok := byPath[abs]
+	if !ok {
+		return
+	}
+
+	if err := s.Reload(name); err != nil {
+		log.Printf("reload %v: %v", name, err)
+	}
+}